@@ -0,0 +1,419 @@
+// Package build provides an API for authoring new disk images, as the
+// write-side companion to grokdisk's read-only analysis: a Builder lays
+// out an MBR or GPT partition table from a declarative list of
+// PartitionSpecs, and Build()'s output can be round-tripped straight
+// back through grokdisk.AnalyzeImageFile.
+package build
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"github.com/pkg/errors"
+	"github.com/tenex/grokdisk"
+	"hash/crc32"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+const (
+	// DefaultAlignment is the default byte alignment every partition's
+	// start offset is rounded up to, including an explicit StartOffset
+	DefaultAlignment = 1 << 20 // 1 MiB
+
+	gptPartitionEntryCount = 128
+)
+
+// PartitionSpec declaratively describes one partition to lay out
+type PartitionSpec struct {
+	// Name is stored in the GPT partition name field; ignored for MBR
+	Name string
+	// TypeGUID is the GPT partition type GUID (see grokdisk.ParseGUID
+	// for the expected format). For MBR builds, use MBRType instead.
+	TypeGUID string
+	// MBRType is the MBR partition type byte, used only when the
+	// Builder's TableType is grokdisk.TableTypeMBR
+	MBRType byte
+	// StartOffset is the partition's desired byte offset. If zero, the
+	// builder places the partition immediately after the previous one,
+	// rounded up to Alignment.
+	StartOffset uint64
+	// Size is the partition's length in bytes
+	Size uint64
+	// FS names a registered FSFormatter to run against the partition
+	// once it's laid out (e.g. "fat32", "ext4"). Leave empty to leave
+	// the partition unformatted.
+	FS string
+	// Label is passed through to the FSFormatter, if any
+	Label string
+	// Flags is stored in the GPT partition attributes field; ignored
+	// for MBR
+	Flags uint64
+}
+
+// FSFormatter populates a freshly laid out partition with a filesystem,
+// mirroring one of the read-side drivers under grokdisk/fs
+type FSFormatter interface {
+	Format(w *os.File, offset, size uint64, label string) error
+}
+
+var formatters = map[string]FSFormatter{}
+
+// RegisterFormatter makes f available to Builder.Build() for any
+// PartitionSpec whose FS field equals fsType
+func RegisterFormatter(fsType string, f FSFormatter) {
+	formatters[fsType] = f
+}
+
+// Builder accumulates partition layout for a new disk image
+type Builder struct {
+	path       string
+	size       uint64
+	sectorSize uint16
+	tableType  grokdisk.TableType
+	alignment  uint64
+	gptGap     uint64
+	partitions []PartitionSpec
+}
+
+// NewBuilder returns a Builder that will write a GPT-partitioned image
+// of size bytes to path, using 512-byte sectors and 1 MiB alignment by
+// default
+func NewBuilder(path string, size uint64) *Builder {
+	return &Builder{
+		path:       path,
+		size:       size,
+		sectorSize: 512,
+		tableType:  grokdisk.TableTypeGPT,
+		alignment:  DefaultAlignment,
+	}
+}
+
+// WithTableType selects MBR or GPT output. GPT is the default.
+func (b *Builder) WithTableType(t grokdisk.TableType) *Builder {
+	b.tableType = t
+	return b
+}
+
+// WithSectorSize overrides the default 512-byte sector size
+func (b *Builder) WithSectorSize(sectorSize uint16) *Builder {
+	b.sectorSize = sectorSize
+	return b
+}
+
+// WithAlignment overrides the default 1 MiB partition start alignment
+func (b *Builder) WithAlignment(alignment uint64) *Builder {
+	b.alignment = alignment
+	return b
+}
+
+// WithGPTGap reserves an extra gptGap bytes between the GPT structures
+// and the first partition, for bootloader payloads that live outside
+// any partition (e.g. GRUB's core.img in a BIOS boot gap). GPT only.
+func (b *Builder) WithGPTGap(gptGap uint64) *Builder {
+	b.gptGap = gptGap
+	return b
+}
+
+// AddPartition appends spec to the layout, in the order partitions will
+// be written
+func (b *Builder) AddPartition(spec PartitionSpec) *Builder {
+	b.partitions = append(b.partitions, spec)
+	return b
+}
+
+// Build writes the image file, partition table, and (for any spec with
+// FS set) the formatted filesystem
+func (b *Builder) Build() error {
+	file, err := os.Create(b.path)
+	if err != nil {
+		return errors.Wrap(err, "could not create image file")
+	}
+	defer file.Close()
+
+	if err := file.Truncate(int64(b.size)); err != nil {
+		return errors.Wrap(err, "could not size image file")
+	}
+
+	var layout []placedPartition
+	switch b.tableType {
+	case grokdisk.TableTypeGPT:
+		layout, err = b.writeGPT(file)
+	default:
+		layout, err = b.writeMBR(file)
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, placed := range layout {
+		if placed.spec.FS == "" {
+			continue
+		}
+		formatter, ok := formatters[placed.spec.FS]
+		if !ok {
+			return errors.Errorf("no FSFormatter registered for %q", placed.spec.FS)
+		}
+		if err := formatter.Format(file, placed.startByte, placed.sizeBytes, placed.spec.Label); err != nil {
+			return errors.Wrapf(err, "formatting partition %q", placed.spec.Name)
+		}
+	}
+
+	return nil
+}
+
+type placedPartition struct {
+	spec      PartitionSpec
+	startByte uint64
+	sizeBytes uint64
+}
+
+// alignUp rounds offset up to the nearest multiple of alignment
+func alignUp(offset, alignment uint64) uint64 {
+	if alignment == 0 {
+		return offset
+	}
+	return (offset + alignment - 1) / alignment * alignment
+}
+
+func (b *Builder) placePartitions(firstUsableByte, lastUsableByte uint64) ([]placedPartition, error) {
+	layout := make([]placedPartition, 0, len(b.partitions))
+	cursor := alignUp(firstUsableByte, b.alignment)
+
+	for _, spec := range b.partitions {
+		start := cursor
+		if spec.StartOffset != 0 {
+			start = alignUp(spec.StartOffset, b.alignment)
+		}
+		end := start + spec.Size
+		if end > lastUsableByte+1 {
+			return nil, errors.Errorf("partition %q (%d-%d) overruns usable area ending at %d", spec.Name, start, end, lastUsableByte)
+		}
+		layout = append(layout, placedPartition{spec: spec, startByte: start, sizeBytes: spec.Size})
+		cursor = alignUp(end, b.alignment)
+	}
+	return layout, nil
+}
+
+func randomGUID() ([16]byte, error) {
+	var g [16]byte
+	if _, err := rand.Read(g[:]); err != nil {
+		return g, errors.Wrap(err, "could not generate GUID")
+	}
+	// RFC 4122 version 4, variant 1. The version nibble lives in byte 7
+	// because the third field is stored little-endian (see FormatGUID).
+	g[7] = (g[7] & 0x0F) | 0x40
+	g[8] = (g[8] & 0x3F) | 0x80
+	return g, nil
+}
+
+func encodeUTF16Name(name string) [72]byte {
+	var out [72]byte
+	units := utf16.Encode([]rune(name))
+	for i, unit := range units {
+		if i*2+2 > len(out) {
+			break
+		}
+		binary.LittleEndian.PutUint16(out[i*2:i*2+2], unit)
+	}
+	return out
+}
+
+// writeGPT lays out a protective MBR, primary and backup GPT headers,
+// and primary and backup partition entry arrays, then places each
+// partition within the usable LBA range
+func (b *Builder) writeGPT(file *os.File) ([]placedPartition, error) {
+	if len(b.partitions) > gptPartitionEntryCount {
+		return nil, errors.Errorf("GPT partition entry array holds at most %d partitions, got %d", gptPartitionEntryCount, len(b.partitions))
+	}
+
+	sectorSize := uint64(b.sectorSize)
+	totalSectors := b.size / sectorSize
+	entryArrayBytes := uint64(gptPartitionEntryCount) * grokdisk.GPTPartitionEntrySize
+	entryArraySectors := (entryArrayBytes + sectorSize - 1) / sectorSize
+
+	backupHeaderLBA := totalSectors - 1
+	backupEntryArrayLBA := backupHeaderLBA - entryArraySectors
+	firstUsableLBA := alignUp(2+entryArraySectors, b.alignment/sectorSize) + b.gptGap/sectorSize
+	lastUsableLBA := backupEntryArrayLBA - 1
+
+	layout, err := b.placePartitions(firstUsableLBA*sectorSize, lastUsableLBA*sectorSize+sectorSize-1)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]byte, entryArrayBytes)
+	for i, placed := range layout {
+		typeGUID, err := grokdisk.ParseGUID(placed.spec.TypeGUID)
+		if err != nil {
+			return nil, errors.Wrapf(err, "partition %q", placed.spec.Name)
+		}
+		uniqueGUID, err := randomGUID()
+		if err != nil {
+			return nil, err
+		}
+
+		entry := grokdisk.GPTPartitionMetadata{
+			PartitionTypeGUID:    typeGUID,
+			UniquePartitionGUID:  uniqueGUID,
+			StartingLBA:          placed.startByte / sectorSize,
+			EndingLBA:            (placed.startByte+placed.sizeBytes)/sectorSize - 1,
+			Attributes:           placed.spec.Flags,
+			PartitionNameUTF16LE: encodeUTF16Name(placed.spec.Name),
+		}
+
+		buf := &bytes.Buffer{}
+		if err := binary.Write(buf, binary.LittleEndian, entry); err != nil {
+			return nil, errors.Wrap(err, "could not encode GPT partition entry")
+		}
+		copy(entries[i*grokdisk.GPTPartitionEntrySize:], buf.Bytes())
+	}
+	entryArrayCRC32 := crc32.ChecksumIEEE(entries)
+
+	diskGUID, err := randomGUID()
+	if err != nil {
+		return nil, err
+	}
+
+	primary := grokdisk.GPTHeader{
+		Signature:                [8]byte{'E', 'F', 'I', ' ', 'P', 'A', 'R', 'T'},
+		Revision:                 0x00010000,
+		HeaderSize:               grokdisk.GPTHeaderSize,
+		CurrentLBA:               1,
+		BackupLBA:                backupHeaderLBA,
+		FirstUsableLBA:           firstUsableLBA,
+		LastUsableLBA:            lastUsableLBA,
+		DiskGUID:                 diskGUID,
+		PartitionEntryLBA:        2,
+		NumberOfPartitionEntries: gptPartitionEntryCount,
+		SizeOfPartitionEntry:     grokdisk.GPTPartitionEntrySize,
+		PartitionEntryArrayCRC32: entryArrayCRC32,
+	}
+	backup := primary
+	backup.CurrentLBA, backup.BackupLBA = backupHeaderLBA, 1
+	backup.PartitionEntryLBA = backupEntryArrayLBA
+
+	if err := writeGPTHeader(file, &primary, sectorSize); err != nil {
+		return nil, err
+	}
+	if err := writeGPTHeader(file, &backup, sectorSize); err != nil {
+		return nil, err
+	}
+	if _, err := file.WriteAt(entries, int64(2*sectorSize)); err != nil {
+		return nil, errors.Wrap(err, "could not write primary GPT partition entries")
+	}
+	if _, err := file.WriteAt(entries, int64(backupEntryArrayLBA*sectorSize)); err != nil {
+		return nil, errors.Wrap(err, "could not write backup GPT partition entries")
+	}
+
+	if err := writeProtectiveMBR(file, totalSectors); err != nil {
+		return nil, err
+	}
+
+	return layout, nil
+}
+
+// writeGPTHeader encodes header (computing its CRC32 last, over the
+// header with the CRC32 field itself zeroed) and writes it at its own
+// CurrentLBA
+func writeGPTHeader(file *os.File, header *grokdisk.GPTHeader, sectorSize uint64) error {
+	buf := &bytes.Buffer{}
+	if err := binary.Write(buf, binary.LittleEndian, header); err != nil {
+		return errors.Wrap(err, "could not encode GPT header")
+	}
+	raw := buf.Bytes()
+	binary.LittleEndian.PutUint32(raw[16:20], 0)
+	header.HeaderCRC32 = crc32.ChecksumIEEE(raw)
+	binary.LittleEndian.PutUint32(raw[16:20], header.HeaderCRC32)
+
+	if _, err := file.WriteAt(raw, int64(header.CurrentLBA*sectorSize)); err != nil {
+		return errors.Wrap(err, "could not write GPT header")
+	}
+	return nil
+}
+
+// writeProtectiveMBR writes a single 0xEE entry covering the disk (or as
+// much of it as a 32-bit sector count can address), satisfying the
+// protective-MBR detection grokdisk.AnalyzeImageFile looks for
+func writeProtectiveMBR(file *os.File, totalSectors uint64) error {
+	coveredSectors := totalSectors - 1
+	if coveredSectors > 0xFFFFFFFF {
+		coveredSectors = 0xFFFFFFFF
+	}
+
+	protective := grokdisk.PartitionMetadata{
+		StartHead:      0,
+		StartSector:    2,
+		StartCylinder:  0,
+		PartitionType:  grokdisk.MBRProtectiveType,
+		EndHead:        0xFF,
+		EndSector:      0xFF,
+		EndCylinder:    0xFF,
+		FirstSectorLBA: 1,
+		SectorCount:    uint32(coveredSectors),
+	}
+
+	buf := &bytes.Buffer{}
+	if err := binary.Write(buf, binary.LittleEndian, protective); err != nil {
+		return errors.Wrap(err, "could not encode protective MBR entry")
+	}
+	if _, err := file.WriteAt(buf.Bytes(), grokdisk.MBRPartitionTableOffset); err != nil {
+		return errors.Wrap(err, "could not write protective MBR entry")
+	}
+
+	bootSignature := []byte{0x55, 0xAA}
+	if _, err := file.WriteAt(bootSignature, 510); err != nil {
+		return errors.Wrap(err, "could not write MBR boot signature")
+	}
+	return nil
+}
+
+// writeMBR lays out up to four primary partitions directly in the
+// classic MBR table; extended/logical partitions aren't supported by
+// the builder
+func (b *Builder) writeMBR(file *os.File) ([]placedPartition, error) {
+	if len(b.partitions) > 4 {
+		return nil, errors.New("MBR tables support at most 4 primary partitions")
+	}
+
+	sectorSize := uint64(b.sectorSize)
+	layout, err := b.placePartitions(sectorSize, b.size-1)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, placed := range layout {
+		typeByte := placed.spec.MBRType
+		if typeByte == 0 && placed.spec.TypeGUID != "" {
+			parsed, err := strconv.ParseUint(strings.TrimPrefix(placed.spec.TypeGUID, "0x"), 16, 8)
+			if err != nil {
+				return nil, errors.Wrapf(err, "partition %q has no MBRType and TypeGUID isn't a type byte", placed.spec.Name)
+			}
+			typeByte = byte(parsed)
+		}
+
+		entry := grokdisk.PartitionMetadata{
+			PartitionType:  typeByte,
+			FirstSectorLBA: uint32(placed.startByte / sectorSize),
+			SectorCount:    uint32(placed.sizeBytes / sectorSize),
+		}
+
+		buf := &bytes.Buffer{}
+		if err := binary.Write(buf, binary.LittleEndian, entry); err != nil {
+			return nil, errors.Wrap(err, "could not encode MBR partition entry")
+		}
+		offset := grokdisk.MBRPartitionTableOffset + i*grokdisk.MBRPartitionTableSize
+		if _, err := file.WriteAt(buf.Bytes(), int64(offset)); err != nil {
+			return nil, errors.Wrap(err, "could not write MBR partition entry")
+		}
+	}
+
+	bootSignature := []byte{0x55, 0xAA}
+	if _, err := file.WriteAt(bootSignature, 510); err != nil {
+		return nil, errors.Wrap(err, "could not write MBR boot signature")
+	}
+
+	return layout, nil
+}
@@ -0,0 +1,85 @@
+package grokdisk
+
+import "github.com/pkg/errors"
+
+const (
+	// legacyHeadsPerCylinder and legacySectorsPerTrack are the geometry
+	// CHS fields are almost universally encoded against on modern
+	// (LBA-addressed) disks, regardless of the drive's real geometry
+	legacyHeadsPerCylinder = 255
+	legacySectorsPerTrack  = 63
+)
+
+// StartCHS decodes the partition's starting cylinder/head/sector. The
+// CHS encoding packs the cylinder's high two bits into the top of the
+// sector byte.
+func (m *PartitionMetadata) StartCHS() (cyl, head, sector uint16) {
+	return decodeCHS(m.StartCylinder, m.StartHead, m.StartSector)
+}
+
+// EndCHS decodes the partition's ending cylinder/head/sector
+func (m *PartitionMetadata) EndCHS() (cyl, head, sector uint16) {
+	return decodeCHS(m.EndCylinder, m.EndHead, m.EndSector)
+}
+
+func decodeCHS(cylByte, headByte, sectorByte byte) (cyl, head, sector uint16) {
+	cyl = uint16(cylByte) | uint16(sectorByte&0xC0)<<2
+	head = uint16(headByte)
+	sector = uint16(sectorByte & 0x3F)
+	return
+}
+
+// isCHSOverflowMarker reports whether a CHS field is the conventional
+// (1023, 254, 63) "too big to represent in CHS" marker, in which case
+// it isn't meaningful to validate against the LBA fields
+func isCHSOverflowMarker(cyl, head, sector uint16) bool {
+	return cyl == 1023 && head == 254 && sector == 63
+}
+
+// chsToLBA converts a CHS tuple to an LBA under the assumed legacy
+// geometry, so it can be compared against the partition's own
+// FirstSectorLBA/SectorCount
+func chsToLBA(cyl, head, sector uint16) uint32 {
+	return (uint32(cyl)*legacyHeadsPerCylinder+uint32(head))*legacySectorsPerTrack + uint32(sector) - 1
+}
+
+// Validate cross-checks the partition's CHS fields against its LBA
+// fields under the standard 255-heads/63-sectors-per-track geometry.
+// Mismatches here are a common forensic tell for manually authored or
+// tampered MBRs, since real partitioning tools keep the two in sync.
+func (p *MBRPartition) Validate() error {
+	// An unused partition table slot - status and type both zero, no LBA
+	// fields set - has no CHS/LBA relationship to check. Without this,
+	// chsToLBA(0,0,0) underflows (0*63+0-1 as a uint32) and never equals
+	// the zeroed FirstSectorLBA, so every ordinary empty slot would
+	// report as tampered.
+	if p.Status == 0 && p.PartitionType == 0 && p.FirstSectorLBA == 0 && p.SectorCount == 0 {
+		return nil
+	}
+
+	startCyl, startHead, startSector := p.StartCHS()
+	if !isCHSOverflowMarker(startCyl, startHead, startSector) {
+		expected := chsToLBA(startCyl, startHead, startSector)
+		if expected != p.FirstSectorLBA {
+			return errors.Errorf(
+				"start CHS (%d,%d,%d) implies LBA %d, but FirstSectorLBA is %d",
+				startCyl, startHead, startSector, expected, p.FirstSectorLBA)
+		}
+	}
+
+	endCyl, endHead, endSector := p.EndCHS()
+	if p.SectorCount == 0 {
+		return nil
+	}
+	if !isCHSOverflowMarker(endCyl, endHead, endSector) {
+		expected := chsToLBA(endCyl, endHead, endSector)
+		lastLBA := p.FirstSectorLBA + p.SectorCount - 1
+		if expected != lastLBA {
+			return errors.Errorf(
+				"end CHS (%d,%d,%d) implies LBA %d, but FirstSectorLBA+SectorCount-1 is %d",
+				endCyl, endHead, endSector, expected, lastLBA)
+		}
+	}
+
+	return nil
+}
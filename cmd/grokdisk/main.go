@@ -0,0 +1,94 @@
+// Command grokdisk is a small example CLI demonstrating that
+// grokdisk/fs drivers can list and read files on a disk image without
+// root privileges, loop devices, or kernel mount support.
+package main
+
+import (
+	"fmt"
+	"github.com/tenex/grokdisk"
+	"io"
+	"io/fs"
+	"os"
+	"strconv"
+)
+
+func main() {
+	if len(os.Args) < 4 {
+		usage()
+		os.Exit(2)
+	}
+
+	command := os.Args[1]
+	imagePath := os.Args[2]
+	partitionIndex, err := strconv.Atoi(os.Args[3])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid partition index %q: %v\n", os.Args[3], err)
+		os.Exit(2)
+	}
+
+	path := "."
+	if len(os.Args) > 4 {
+		path = os.Args[4]
+	}
+
+	partition, err := openPartition(imagePath, partitionIndex)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	switch command {
+	case "ls":
+		err = runLs(partition, path)
+	case "cat":
+		err = runCat(partition, path)
+	default:
+		usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: grokdisk <ls|cat> <image> <partition-index> [path]")
+}
+
+func openPartition(imagePath string, partitionIndex int) (fs.FS, error) {
+	imageMetadata, err := grokdisk.AnalyzeImageFile(imagePath)
+	if err != nil {
+		return nil, fmt.Errorf("analyzing %s: %w", imagePath, err)
+	}
+	if partitionIndex < 0 || partitionIndex >= len(imageMetadata.Partitions) {
+		return nil, fmt.Errorf("partition %d out of range (found %d)", partitionIndex, len(imageMetadata.Partitions))
+	}
+	return imageMetadata.Partitions[partitionIndex].Open()
+}
+
+func runLs(partition fs.FS, path string) error {
+	entries, err := fs.ReadDir(partition, path)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		suffix := ""
+		if entry.IsDir() {
+			suffix = "/"
+		}
+		fmt.Println(entry.Name() + suffix)
+	}
+	return nil
+}
+
+func runCat(partition fs.FS, path string) error {
+	file, err := partition.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = io.Copy(os.Stdout, file)
+	return err
+}
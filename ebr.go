@@ -0,0 +1,91 @@
+package grokdisk
+
+import (
+	"encoding/binary"
+	"github.com/pkg/errors"
+	"os"
+)
+
+// maxExtendedChainLength caps how many EBRs we'll follow for a single
+// extended partition, as a guard against malformed or maliciously
+// circular chains that don't otherwise trip the visited-set check
+const maxExtendedChainLength = 128
+
+// extendedPartitionTypes are the PartitionType bytes that mark a primary
+// partition as an extended (EBR chain) container rather than real data
+var extendedPartitionTypes = map[byte]bool{
+	0x05: true, // CHS extended
+	0x0F: true, // LBA extended
+	0x85: true, // Linux extended
+}
+
+func isExtendedPartitionType(partitionType byte) bool {
+	return extendedPartitionTypes[partitionType]
+}
+
+// readExtendedChain walks the linked list of Extended Boot Records
+// rooted at extended, returning one *MBRPartition per logical partition
+// found along the way.
+//
+// Each EBR holds two partition entries at the usual MBRPartitionTableOffset:
+// the first describes the logical partition itself, with FirstSectorLBA
+// relative to the EBR that contains it; the second either points to the
+// next EBR, with FirstSectorLBA relative to the outer extended partition,
+// or is zeroed to terminate the chain.
+func readExtendedChain(imageFile *os.File, imageMetadata *ImageFileMetadata, extended *MBRPartition) ([]*MBRPartition, error) {
+	extendedStartLBA := uint64(extended.FirstSectorLBA)
+	currentEBRLBA := extendedStartLBA
+
+	visited := make(map[uint64]bool)
+	logicalPartitions := make([]*MBRPartition, 0, 4)
+
+	for hops := 0; hops < maxExtendedChainLength; hops++ {
+		if visited[currentEBRLBA] {
+			return nil, errors.Errorf("cycle detected in EBR chain at LBA %d", currentEBRLBA)
+		}
+		visited[currentEBRLBA] = true
+
+		logicalEntry, nextEntry, err := readEBR(imageFile, imageMetadata, currentEBRLBA)
+		if err != nil {
+			return nil, err
+		}
+
+		if logicalEntry.PartitionType != 0 {
+			logical := &MBRPartition{
+				PartitionMetadata: logicalEntry,
+				ImageFile:         imageMetadata,
+				ParentExtended:    extended,
+			}
+			logical.FirstSectorLBA = uint32(currentEBRLBA) + logicalEntry.FirstSectorLBA
+			logicalPartitions = append(logicalPartitions, logical)
+		}
+
+		if nextEntry.PartitionType == 0 || nextEntry.FirstSectorLBA == 0 {
+			break
+		}
+		currentEBRLBA = extendedStartLBA + uint64(nextEntry.FirstSectorLBA)
+	}
+
+	return logicalPartitions, nil
+}
+
+// readEBR reads the two partition entries found at offset
+// MBRPartitionTableOffset within the EBR at ebrLBA
+func readEBR(imageFile *os.File, imageMetadata *ImageFileMetadata, ebrLBA uint64) (*PartitionMetadata, *PartitionMetadata, error) {
+	offset := int64(ebrLBA)*int64(imageMetadata.SectorSize) + MBRPartitionTableOffset
+	if _, err := imageFile.Seek(offset, os.SEEK_SET); err != nil {
+		return nil, nil, errors.Wrap(err, "could not seek EBR")
+	}
+
+	logicalEntry := &PartitionMetadata{}
+	if err := binary.Read(imageFile, binary.LittleEndian, logicalEntry); err != nil {
+		return nil, nil, errors.Wrap(err, "could not read EBR logical partition entry")
+	}
+
+	nextEntry := &PartitionMetadata{}
+	if err := binary.Read(imageFile, binary.LittleEndian, nextEntry); err != nil {
+		return nil, nil, errors.Wrap(err, "could not read EBR next-EBR entry")
+	}
+
+	return logicalEntry, nextEntry, nil
+}
@@ -0,0 +1,142 @@
+package ext
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+)
+
+// buildExt4Image hand-assembles a minimal, valid ext4-like image with
+// two block groups and 64-byte (EXT4_FEATURE_INCOMPAT_64BIT) group
+// descriptors, a root directory holding one entry ("nested.txt") whose
+// inode lives in the second block group, and a two-extent file whose
+// first extent (the one processed second, so an overbroad range would
+// clobber the second extent's mapping) carries the "unwritten extent"
+// ee_len marker. This exercises both the group-descriptor-stride and
+// extent-length bugs against a real parse, not just in isolation.
+func buildExt4Image(t *testing.T) ([]byte, []byte) {
+	t.Helper()
+
+	const (
+		blockSize      = 1024
+		inodesPerGroup = 8
+		inodeSize      = 128
+		descSize       = 64
+
+		superblockBlock  = 1
+		gdtBlock         = 2
+		group0InodeTable = 3
+		rootDirDataBlock = 4
+		group1InodeTable = 5
+		block1Data       = 6 // holds logical block 1 ('B' bytes)
+		block0Data       = 7 // holds logical block 0 ('A' filler)
+		wrongBlock       = 8 // never legitimately referenced; reading it means the bug is back
+		totalBlocks      = 9
+
+		rootInodeNum = 2
+		fileInodeNum = 10
+	)
+
+	img := make([]byte, totalBlocks*blockSize)
+
+	// --- superblock, at byte offset 1024 ---
+	sb := img[superblockBlock*blockSize : superblockBlock*blockSize+blockSize]
+	binary.LittleEndian.PutUint32(sb[0x14:0x18], 1)              // s_first_data_block
+	binary.LittleEndian.PutUint32(sb[0x18:0x1C], 0)              // s_log_block_size -> 1024<<0
+	binary.LittleEndian.PutUint32(sb[0x28:0x2C], inodesPerGroup) // s_inodes_per_group
+	binary.LittleEndian.PutUint16(sb[56:58], extMagic)           // s_magic
+	binary.LittleEndian.PutUint32(sb[0x4C:0x50], 1)              // s_rev_level (dynamic)
+	binary.LittleEndian.PutUint16(sb[0x58:0x5A], inodeSize)      // s_inode_size
+	binary.LittleEndian.PutUint32(sb[0x60:0x64], 0x80)           // s_feature_incompat: 64BIT
+	binary.LittleEndian.PutUint16(sb[0xFE:0x100], descSize)      // s_desc_size
+
+	// --- group descriptor table, at block 2 ---
+	gdt := img[gdtBlock*blockSize : gdtBlock*blockSize+blockSize]
+	binary.LittleEndian.PutUint32(gdt[0*descSize+8:0*descSize+12], group0InodeTable)
+	binary.LittleEndian.PutUint32(gdt[1*descSize+8:1*descSize+12], group1InodeTable)
+
+	putInode := func(table []byte, indexInGroup int, mode uint16, sizeLo, flags uint32, block [60]byte) {
+		off := indexInGroup * inodeSize
+		binary.LittleEndian.PutUint16(table[off:off+2], mode)
+		binary.LittleEndian.PutUint32(table[off+4:off+8], sizeLo)
+		binary.LittleEndian.PutUint32(table[off+32:off+36], flags)
+		copy(table[off+40:off+100], block[:])
+	}
+
+	// --- root inode (#2): group 0, index 1, a directory with one data block ---
+	group0Table := img[group0InodeTable*blockSize : group0InodeTable*blockSize+blockSize]
+	var rootBlock [60]byte
+	binary.LittleEndian.PutUint32(rootBlock[0:4], rootDirDataBlock)
+	rootIndex := (rootInodeNum - 1) % inodesPerGroup
+	putInode(group0Table, rootIndex, 0x4000, blockSize, 0, rootBlock)
+
+	// --- root directory data (block 4): one dirent naming inode 10 ---
+	dirData := img[rootDirDataBlock*blockSize : rootDirDataBlock*blockSize+blockSize]
+	name := "nested.txt"
+	binary.LittleEndian.PutUint32(dirData[0:4], fileInodeNum)
+	binary.LittleEndian.PutUint16(dirData[4:6], blockSize) // rec_len spans the whole block
+	dirData[6] = byte(len(name))
+	dirData[7] = 1 // file_type: regular file
+	copy(dirData[8:8+len(name)], name)
+
+	// --- file inode (#10): group 1, index 1, two extents:
+	//   - logical block 1 -> block1Data, listed FIRST
+	//   - logical block 0 -> block0Data, listed SECOND, with ee_len
+	//     carrying the unwritten-extent marker (the true length is 1,
+	//     not 32769). If that marker isn't masked off, this entry's
+	//     fill loop runs 32769 iterations and overwrites logical block
+	//     1's already-correct mapping with block0Data+1, i.e. wrongBlock.
+	group1Table := img[group1InodeTable*blockSize : group1InodeTable*blockSize+blockSize]
+	var fileBlock [60]byte
+	binary.LittleEndian.PutUint16(fileBlock[0:2], 0xF30A) // extent header magic
+	binary.LittleEndian.PutUint16(fileBlock[2:4], 2)      // two entries
+	binary.LittleEndian.PutUint16(fileBlock[6:8], 0)      // depth: leaf
+
+	entry0 := fileBlock[12:24]
+	binary.LittleEndian.PutUint32(entry0[0:4], 1) // ee_block: logical 1
+	binary.LittleEndian.PutUint16(entry0[4:6], 1) // ee_len: 1 block
+	binary.LittleEndian.PutUint16(entry0[6:8], 0) // ee_start_hi
+	binary.LittleEndian.PutUint32(entry0[8:12], block1Data)
+
+	entry1 := fileBlock[24:36]
+	binary.LittleEndian.PutUint32(entry1[0:4], 0)       // ee_block: logical 0
+	binary.LittleEndian.PutUint16(entry1[4:6], 32768+1) // ee_len: unwritten, true length 1
+	binary.LittleEndian.PutUint16(entry1[6:8], 0)       // ee_start_hi
+	binary.LittleEndian.PutUint32(entry1[8:12], block0Data)
+
+	size := uint32(2 * blockSize)
+	fileIndex := (fileInodeNum - 1) % inodesPerGroup
+	putInode(group1Table, fileIndex, 0x8180, size, extentsFlag, fileBlock)
+
+	filler := bytes.Repeat([]byte{'A'}, blockSize)
+	copy(img[block0Data*blockSize:], filler)
+	copy(img[block1Data*blockSize:], bytes.Repeat([]byte{'B'}, blockSize))
+	copy(img[wrongBlock*blockSize:], bytes.Repeat([]byte{'Z'}, blockSize))
+
+	want := append(append([]byte{}, filler...), bytes.Repeat([]byte{'B'}, blockSize)...)
+	return img, want
+}
+
+func TestMultiGroupInodeAndUnwrittenExtent(t *testing.T) {
+	img, want := buildExt4Image(t)
+
+	fsys, err := New(bytes.NewReader(img), uint64(len(img)))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	f, err := fsys.Open("nested.txt")
+	if err != nil {
+		t.Fatalf("Open(nested.txt): %v", err)
+	}
+	defer f.Close()
+
+	got, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("file content mismatch (len got=%d want=%d)", len(got), len(want))
+	}
+}
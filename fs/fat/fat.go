@@ -0,0 +1,479 @@
+// Package fat implements a minimal, read-only driver for FAT12/16/32
+// filesystems that satisfies io/fs.FS directly against a partition's
+// byte range, without loop-mounting or shelling out to the kernel.
+package fat
+
+import (
+	"encoding/binary"
+	"github.com/pkg/errors"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// bpb is the subset of the BIOS Parameter Block we need to locate the
+// FAT, the root directory, and the data region
+type bpb struct {
+	BytesPerSector    uint16
+	SectorsPerCluster uint8
+	ReservedSectors   uint16
+	NumFATs           uint8
+	RootEntryCount    uint16
+	TotalSectors16    uint16
+	SectorsPerFAT16   uint16
+	TotalSectors32    uint32
+	SectorsPerFAT32   uint32
+	RootCluster       uint32
+}
+
+// FS is a read-only FAT12/16/32 filesystem, addressed against r starting
+// at byte 0 (callers pass an io.SectionReader bounded to the partition)
+type FS struct {
+	r    io.ReaderAt
+	size uint64
+
+	bitsPerFATEntry int // 12, 16, or 32
+
+	bytesPerSector    uint32
+	sectorsPerCluster uint32
+	bytesPerCluster   uint32
+
+	fatOffset int64
+	fatSize   uint32 // sectors
+
+	rootDirOffset int64 // FAT12/16 only
+	rootDirSize   int64 // FAT12/16 only, in bytes
+	rootCluster   uint32 // FAT32 only
+
+	dataOffset int64 // byte offset of cluster 2
+}
+
+// New parses the BIOS Parameter Block at the start of r and returns a
+// driver for the FAT12/16/32 filesystem it describes
+func New(r io.ReaderAt, size uint64) (*FS, error) {
+	raw := make([]byte, 90)
+	if _, err := r.ReadAt(raw, 0); err != nil {
+		return nil, errors.Wrap(err, "could not read BPB")
+	}
+
+	b := bpb{
+		BytesPerSector:    binary.LittleEndian.Uint16(raw[11:13]),
+		SectorsPerCluster: raw[13],
+		ReservedSectors:   binary.LittleEndian.Uint16(raw[14:16]),
+		NumFATs:           raw[16],
+		RootEntryCount:    binary.LittleEndian.Uint16(raw[17:19]),
+		TotalSectors16:    binary.LittleEndian.Uint16(raw[19:21]),
+		SectorsPerFAT16:   binary.LittleEndian.Uint16(raw[22:24]),
+		TotalSectors32:    binary.LittleEndian.Uint32(raw[32:36]),
+		SectorsPerFAT32:   binary.LittleEndian.Uint32(raw[36:40]),
+		RootCluster:       binary.LittleEndian.Uint32(raw[44:48]),
+	}
+	if b.BytesPerSector == 0 || b.SectorsPerCluster == 0 {
+		return nil, errors.New("not a FAT filesystem: zero BytesPerSector/SectorsPerCluster")
+	}
+
+	fatSize := uint32(b.SectorsPerFAT16)
+	if fatSize == 0 {
+		fatSize = b.SectorsPerFAT32
+	}
+
+	totalSectors := uint32(b.TotalSectors16)
+	if totalSectors == 0 {
+		totalSectors = b.TotalSectors32
+	}
+
+	rootDirSectors := (uint32(b.RootEntryCount)*32 + uint32(b.BytesPerSector) - 1) / uint32(b.BytesPerSector)
+	firstDataSector := uint32(b.ReservedSectors) + uint32(b.NumFATs)*fatSize + rootDirSectors
+	dataSectors := totalSectors - firstDataSector
+	clusterCount := dataSectors / uint32(b.SectorsPerCluster)
+
+	bitsPerFATEntry := 32
+	switch {
+	case clusterCount < 4085:
+		bitsPerFATEntry = 12
+	case clusterCount < 65525:
+		bitsPerFATEntry = 16
+	}
+
+	fsys := &FS{
+		r:                 r,
+		size:              size,
+		bitsPerFATEntry:   bitsPerFATEntry,
+		bytesPerSector:    uint32(b.BytesPerSector),
+		sectorsPerCluster: uint32(b.SectorsPerCluster),
+		bytesPerCluster:   uint32(b.SectorsPerCluster) * uint32(b.BytesPerSector),
+		fatOffset:         int64(b.ReservedSectors) * int64(b.BytesPerSector),
+		fatSize:           fatSize,
+		dataOffset:        int64(firstDataSector) * int64(b.BytesPerSector),
+	}
+
+	if bitsPerFATEntry == 32 {
+		fsys.rootCluster = b.RootCluster
+	} else {
+		fsys.rootDirOffset = int64(b.ReservedSectors+uint16(b.NumFATs)*uint16(fatSize)) * int64(b.BytesPerSector)
+		fsys.rootDirSize = int64(rootDirSectors) * int64(b.BytesPerSector)
+	}
+
+	return fsys, nil
+}
+
+const (
+	attrReadOnly  = 0x01
+	attrHidden    = 0x02
+	attrSystem    = 0x04
+	attrVolumeID  = 0x08
+	attrDirectory = 0x10
+	attrLFN       = attrReadOnly | attrHidden | attrSystem | attrVolumeID
+)
+
+// dirent is a decoded directory entry with its long name resolved
+type dirent struct {
+	name    string
+	isDir   bool
+	cluster uint32
+	size    uint32
+}
+
+func (fsys *FS) fatEntry(cluster uint32) (uint32, error) {
+	var offset int64
+	var width int64
+	switch fsys.bitsPerFATEntry {
+	case 12:
+		offset = fsys.fatOffset + int64(cluster)*3/2
+		width = 2
+	case 16:
+		offset = fsys.fatOffset + int64(cluster)*2
+		width = 2
+	default:
+		offset = fsys.fatOffset + int64(cluster)*4
+		width = 4
+	}
+
+	buf := make([]byte, width)
+	if _, err := fsys.r.ReadAt(buf, offset); err != nil {
+		return 0, errors.Wrap(err, "could not read FAT entry")
+	}
+
+	switch fsys.bitsPerFATEntry {
+	case 12:
+		v := binary.LittleEndian.Uint16(buf)
+		if cluster%2 == 0 {
+			return uint32(v & 0x0FFF), nil
+		}
+		return uint32(v >> 4), nil
+	case 16:
+		return uint32(binary.LittleEndian.Uint16(buf)), nil
+	default:
+		return binary.LittleEndian.Uint32(buf) & 0x0FFFFFFF, nil
+	}
+}
+
+func (fsys *FS) isEOC(entry uint32) bool {
+	switch fsys.bitsPerFATEntry {
+	case 12:
+		return entry >= 0x0FF8
+	case 16:
+		return entry >= 0xFFF8
+	default:
+		return entry >= 0x0FFFFFF8
+	}
+}
+
+// clusterChain returns the list of clusters backing a file or directory
+// starting at start, following the FAT until the end-of-chain marker
+func (fsys *FS) clusterChain(start uint32) ([]uint32, error) {
+	chain := make([]uint32, 0, 8)
+	cluster := start
+	seen := make(map[uint32]bool)
+	for cluster >= 2 && !fsys.isEOC(cluster) {
+		if seen[cluster] {
+			return nil, errors.New("cycle detected in FAT cluster chain")
+		}
+		seen[cluster] = true
+		chain = append(chain, cluster)
+
+		next, err := fsys.fatEntry(cluster)
+		if err != nil {
+			return nil, err
+		}
+		cluster = next
+	}
+	return chain, nil
+}
+
+func (fsys *FS) readCluster(cluster uint32) ([]byte, error) {
+	buf := make([]byte, fsys.bytesPerCluster)
+	offset := fsys.dataOffset + int64(cluster-2)*int64(fsys.bytesPerCluster)
+	if _, err := fsys.r.ReadAt(buf, offset); err != nil {
+		return nil, errors.Wrap(err, "could not read data cluster")
+	}
+	return buf, nil
+}
+
+// readDirBytes returns the raw bytes of a directory: the fixed-size root
+// region for FAT12/16, or the cluster chain for FAT32/subdirectories
+func (fsys *FS) readDirBytes(cluster uint32, isRoot bool) ([]byte, error) {
+	if isRoot && fsys.bitsPerFATEntry != 32 {
+		buf := make([]byte, fsys.rootDirSize)
+		if _, err := fsys.r.ReadAt(buf, fsys.rootDirOffset); err != nil {
+			return nil, errors.Wrap(err, "could not read root directory")
+		}
+		return buf, nil
+	}
+
+	chain, err := fsys.clusterChain(cluster)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 0, len(chain)*int(fsys.bytesPerCluster))
+	for _, c := range chain {
+		data, err := fsys.readCluster(c)
+		if err != nil {
+			return nil, err
+		}
+		buf = append(buf, data...)
+	}
+	return buf, nil
+}
+
+// parseDirents decodes a directory's raw bytes into entries, merging LFN
+// (long file name) entries onto the short entry that follows them
+func parseDirents(raw []byte) []dirent {
+	entries := make([]dirent, 0, len(raw)/32)
+	var lfnParts []string
+
+	for offset := 0; offset+32 <= len(raw); offset += 32 {
+		chunk := raw[offset : offset+32]
+		if chunk[0] == 0x00 {
+			break // no more entries
+		}
+		if chunk[0] == 0xE5 {
+			lfnParts = nil
+			continue // deleted entry
+		}
+		if chunk[11] == attrLFN {
+			lfnParts = append(lfnParts, decodeLFNChunk(chunk))
+			continue
+		}
+
+		// short entry layout: Name[11] Attr _reserved _created[7] ClusterHi
+		// _modified[4] ClusterLo Size, 32 bytes total
+		var shortNameRaw [11]byte
+		copy(shortNameRaw[:], chunk[0:11])
+		attr := chunk[11]
+		clusterHi := binary.LittleEndian.Uint16(chunk[20:22])
+		clusterLo := binary.LittleEndian.Uint16(chunk[26:28])
+		size := binary.LittleEndian.Uint32(chunk[28:32])
+
+		name := shortName(shortNameRaw)
+		if len(lfnParts) > 0 {
+			// LFN entries are stored last-chunk-first
+			var b strings.Builder
+			for i := len(lfnParts) - 1; i >= 0; i-- {
+				b.WriteString(lfnParts[i])
+			}
+			name = strings.TrimRight(b.String(), "\x00￿")
+		}
+		lfnParts = nil
+
+		if attr&attrVolumeID != 0 {
+			continue
+		}
+
+		entries = append(entries, dirent{
+			name:    name,
+			isDir:   attr&attrDirectory != 0,
+			cluster: uint32(clusterHi)<<16 | uint32(clusterLo),
+			size:    size,
+		})
+	}
+	return entries
+}
+
+func decodeLFNChunk(chunk []byte) string {
+	units := make([]uint16, 0, 13)
+	ranges := [][2]int{{1, 11}, {14, 26}, {28, 32}}
+	for _, r := range ranges {
+		for i := r[0]; i+1 < r[1]; i += 2 {
+			units = append(units, binary.LittleEndian.Uint16(chunk[i:i+2]))
+		}
+	}
+	return string(utf16.Decode(units))
+}
+
+func shortName(raw [11]byte) string {
+	name := strings.TrimRight(string(raw[0:8]), " ")
+	ext := strings.TrimRight(string(raw[8:11]), " ")
+	if ext == "" {
+		return name
+	}
+	return name + "." + ext
+}
+
+func (fsys *FS) lookup(dirCluster uint32, isRoot bool, name string) (*dirent, error) {
+	raw, err := fsys.readDirBytes(dirCluster, isRoot)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range parseDirents(raw) {
+		if strings.EqualFold(entry.name, name) {
+			entry := entry
+			return &entry, nil
+		}
+	}
+	return nil, nil
+}
+
+// resolve walks name component by component from the root directory
+func (fsys *FS) resolve(name string) (*dirent, error) {
+	root := &dirent{name: ".", isDir: true, cluster: fsys.rootCluster}
+	if name == "." {
+		return root, nil
+	}
+
+	current := root
+	isRoot := true
+	for _, part := range strings.Split(name, "/") {
+		entry, err := fsys.lookup(current.cluster, isRoot, part)
+		if err != nil {
+			return nil, err
+		}
+		if entry == nil {
+			return nil, fs.ErrNotExist
+		}
+		current = entry
+		isRoot = false
+	}
+	return current, nil
+}
+
+// Open implements io/fs.FS
+func (fsys *FS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	entry, err := fsys.resolve(name)
+	if err != nil {
+		if err == fs.ErrNotExist {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+		}
+		return nil, err
+	}
+
+	if entry.isDir {
+		raw, err := fsys.readDirBytes(entry.cluster, name == ".")
+		if err != nil {
+			return nil, err
+		}
+		return &openDir{fsys: fsys, name: name, entries: parseDirents(raw)}, nil
+	}
+
+	chain, err := fsys.clusterChain(entry.cluster)
+	if err != nil {
+		return nil, err
+	}
+	return &openFile{fsys: fsys, name: name, size: entry.size, chain: chain}, nil
+}
+
+type fileInfo struct {
+	name  string
+	size  int64
+	isDir bool
+}
+
+func (fi *fileInfo) Name() string       { return path.Base(fi.name) }
+func (fi *fileInfo) Size() int64        { return fi.size }
+func (fi *fileInfo) ModTime() time.Time { return time.Time{} }
+func (fi *fileInfo) IsDir() bool        { return fi.isDir }
+func (fi *fileInfo) Sys() interface{}   { return nil }
+func (fi *fileInfo) Mode() fs.FileMode {
+	if fi.isDir {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
+
+type openFile struct {
+	fsys  *FS
+	name  string
+	size  uint32
+	chain []uint32
+	pos   int64
+}
+
+func (f *openFile) Stat() (fs.FileInfo, error) {
+	return &fileInfo{name: f.name, size: int64(f.size)}, nil
+}
+
+func (f *openFile) Read(p []byte) (int, error) {
+	if f.pos >= int64(f.size) {
+		return 0, io.EOF
+	}
+	clusterSize := int64(f.fsys.bytesPerCluster)
+	clusterIndex := int(f.pos / clusterSize)
+	if clusterIndex >= len(f.chain) {
+		return 0, io.EOF
+	}
+
+	data, err := f.fsys.readCluster(f.chain[clusterIndex])
+	if err != nil {
+		return 0, err
+	}
+
+	offsetInCluster := f.pos % clusterSize
+	n := copy(p, data[offsetInCluster:])
+
+	remaining := int64(f.size) - f.pos
+	if int64(n) > remaining {
+		n = int(remaining)
+	}
+	f.pos += int64(n)
+	return n, nil
+}
+
+func (f *openFile) Close() error { return nil }
+
+type openDir struct {
+	fsys    *FS
+	name    string
+	entries []dirent
+	offset  int
+}
+
+func (d *openDir) Stat() (fs.FileInfo, error) {
+	return &fileInfo{name: d.name, isDir: true}, nil
+}
+
+func (d *openDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: errors.New("is a directory")}
+}
+
+func (d *openDir) Close() error { return nil }
+
+func (d *openDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	remaining := d.entries[d.offset:]
+	if n <= 0 {
+		n = len(remaining)
+	} else if n > len(remaining) {
+		n = len(remaining)
+	}
+
+	out := make([]fs.DirEntry, 0, n)
+	for _, entry := range remaining[:n] {
+		out = append(out, fs.FileInfoToDirEntry(&fileInfo{
+			name:  entry.name,
+			size:  int64(entry.size),
+			isDir: entry.isDir,
+		}))
+	}
+	d.offset += n
+
+	if n == 0 && len(remaining) == 0 {
+		return out, io.EOF
+	}
+	return out, nil
+}
@@ -0,0 +1,94 @@
+package fat
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/fs"
+	"testing"
+)
+
+// buildFAT12Image hand-assembles a minimal, valid FAT12 image with a
+// single root-directory entry ("FILE.TXT") pointing at a one-cluster
+// file, to exercise the driver against a real (if tiny) filesystem
+// rather than calling its internals directly.
+func buildFAT12Image(t *testing.T, content []byte) []byte {
+	t.Helper()
+
+	const (
+		bytesPerSector    = 512
+		sectorsPerCluster = 1
+		reservedSectors   = 1
+		numFATs           = 1
+		rootEntryCount    = 16
+		fatSizeSectors    = 1
+		totalSectors      = 20
+	)
+
+	img := make([]byte, totalSectors*bytesPerSector)
+
+	binary.LittleEndian.PutUint16(img[11:13], bytesPerSector)
+	img[13] = sectorsPerCluster
+	binary.LittleEndian.PutUint16(img[14:16], reservedSectors)
+	img[16] = numFATs
+	binary.LittleEndian.PutUint16(img[17:19], rootEntryCount)
+	binary.LittleEndian.PutUint16(img[19:21], totalSectors)
+	binary.LittleEndian.PutUint16(img[22:24], fatSizeSectors)
+
+	fatOffset := reservedSectors * bytesPerSector
+	// cluster 2's 12-bit FAT entry, packed at byte offset cluster*3/2,
+	// set to the end-of-chain marker since our file is one cluster long
+	binary.LittleEndian.PutUint16(img[fatOffset+3:fatOffset+5], 0x0FFF)
+
+	rootDirOffset := (reservedSectors + numFATs*fatSizeSectors) * bytesPerSector
+	entry := img[rootDirOffset : rootDirOffset+32]
+	copy(entry[0:11], "FILE    TXT")
+	entry[11] = 0x20                               // archive attribute, not a directory/volume label/LFN
+	binary.LittleEndian.PutUint16(entry[26:28], 2) // starting cluster
+	binary.LittleEndian.PutUint32(entry[28:32], uint32(len(content)))
+
+	rootDirSectors := (rootEntryCount*32 + bytesPerSector - 1) / bytesPerSector
+	firstDataSector := reservedSectors + numFATs*fatSizeSectors + rootDirSectors
+	dataOffset := firstDataSector * bytesPerSector
+	copy(img[dataOffset:], content)
+
+	return img
+}
+
+func TestReadRootDirectoryAndFile(t *testing.T) {
+	content := []byte("hello")
+	img := buildFAT12Image(t, content)
+
+	fsys, err := New(bytes.NewReader(img), uint64(len(img)))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		t.Fatalf("ReadDir(.): %v", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("got %d entries, want 1", len(entries))
+	}
+	if got := entries[0].Name(); got != "FILE.TXT" {
+		t.Fatalf("entry name = %q, want FILE.TXT", got)
+	}
+	if entries[0].IsDir() {
+		t.Fatal("entry reported as directory")
+	}
+
+	f, err := fsys.Open("FILE.TXT")
+	if err != nil {
+		t.Fatalf("Open(FILE.TXT): %v", err)
+	}
+	defer f.Close()
+
+	got, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Fatalf("file content = %q, want %q", got, content)
+	}
+}
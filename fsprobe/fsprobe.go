@@ -0,0 +1,289 @@
+// Package fsprobe identifies the filesystem present on a disk partition
+// by reading well-known superblock signatures, without shelling out to
+// blkid or mount.
+package fsprobe
+
+import (
+	"encoding/binary"
+	"github.com/pkg/errors"
+	"io"
+	"strings"
+)
+
+// FSInfo describes the filesystem identified on a partition
+type FSInfo struct {
+	// Type is the short filesystem name, e.g. "ext2/3/4", "fat32", "ntfs"
+	Type string
+	// UUID is the filesystem UUID/serial, where the format carries one
+	UUID string
+	// Label is the volume label, where the format carries one
+	Label string
+}
+
+// probeFunc inspects r, a reader bounded to exactly one partition of the
+// given size, and returns a non-nil FSInfo if it recognizes the
+// filesystem. A nil, nil return means "not this filesystem".
+type probeFunc func(r io.ReaderAt, size uint64) (*FSInfo, error)
+
+// probes is tried in order; the first match wins. Order mostly doesn't
+// matter since each checks a distinct signature, but cheaper, more
+// specific checks go first.
+var probes = []probeFunc{
+	probeExt,
+	probeFAT,
+	probeNTFS,
+	probeExFAT,
+	probeXFS,
+	probeBtrfs,
+	probeSquashfs,
+	probeISO9660,
+	probeLUKS,
+	probeSwap,
+}
+
+// Probe identifies the filesystem on a partition by reading r, which must
+// be bounded to exactly the partition's byte range (size bytes long). It
+// returns (nil, nil) if no known filesystem signature is found.
+func Probe(r io.ReaderAt, size uint64) (*FSInfo, error) {
+	for _, probe := range probes {
+		info, err := probe(r, size)
+		if err != nil {
+			return nil, err
+		}
+		if info != nil {
+			return info, nil
+		}
+	}
+	return nil, nil
+}
+
+// readAt is a small helper that turns a short read past EOF into a clean
+// "not a match" rather than an error, since partitions can be smaller
+// than the offset a given probe wants to check.
+func readAt(r io.ReaderAt, buf []byte, offset int64, size uint64) (bool, error) {
+	if offset < 0 || uint64(offset)+uint64(len(buf)) > size {
+		return false, nil
+	}
+	if _, err := r.ReadAt(buf, offset); err != nil {
+		if err == io.EOF {
+			return false, nil
+		}
+		return false, errors.Wrap(err, "could not read candidate superblock")
+	}
+	return true, nil
+}
+
+// cString trims trailing NULs (and any padding after the first NUL) from
+// a fixed-width on-disk string field
+func cString(b []byte) string {
+	if i := indexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return strings.TrimRight(string(b), " ")
+}
+
+func indexByte(b []byte, c byte) int {
+	for i, v := range b {
+		if v == c {
+			return i
+		}
+	}
+	return -1
+}
+
+func probeExt(r io.ReaderAt, size uint64) (*FSInfo, error) {
+	const superblockOffset = 1024
+	buf := make([]byte, 136)
+	ok, err := readAt(r, buf, superblockOffset, size)
+	if err != nil || !ok {
+		return nil, err
+	}
+	if binary.LittleEndian.Uint16(buf[56:58]) != 0xEF53 {
+		return nil, nil
+	}
+	uuid := buf[104:120]
+	label := buf[120:136]
+	return &FSInfo{
+		Type:  "ext2/3/4",
+		UUID:  formatByteUUID(uuid),
+		Label: cString(label),
+	}, nil
+}
+
+func probeFAT(r io.ReaderAt, size uint64) (*FSInfo, error) {
+	buf := make([]byte, 90)
+	ok, err := readAt(r, buf, 0, size)
+	if err != nil || !ok {
+		return nil, err
+	}
+
+	// FAT32 BPB places the filesystem type string at offset 82
+	if strings.HasPrefix(string(buf[82:90]), "FAT32") {
+		return &FSInfo{
+			Type:  "fat32",
+			UUID:  formatFATSerial(buf[67:71]),
+			Label: cString(buf[71:82]),
+		}, nil
+	}
+
+	// FAT12/16 BPB places it at offset 54
+	fsType := string(buf[54:62])
+	if strings.HasPrefix(fsType, "FAT12") || strings.HasPrefix(fsType, "FAT16") {
+		return &FSInfo{
+			Type:  strings.ToLower(strings.TrimRight(fsType, " ")),
+			UUID:  formatFATSerial(buf[39:43]),
+			Label: cString(buf[43:54]),
+		}, nil
+	}
+
+	return nil, nil
+}
+
+func probeNTFS(r io.ReaderAt, size uint64) (*FSInfo, error) {
+	buf := make([]byte, 8)
+	ok, err := readAt(r, buf, 3, size)
+	if err != nil || !ok {
+		return nil, err
+	}
+	if string(buf) != "NTFS    " {
+		return nil, nil
+	}
+	return &FSInfo{Type: "ntfs"}, nil
+}
+
+func probeExFAT(r io.ReaderAt, size uint64) (*FSInfo, error) {
+	buf := make([]byte, 8)
+	ok, err := readAt(r, buf, 3, size)
+	if err != nil || !ok {
+		return nil, err
+	}
+	if string(buf) != "EXFAT   " {
+		return nil, nil
+	}
+	return &FSInfo{Type: "exfat"}, nil
+}
+
+func probeXFS(r io.ReaderAt, size uint64) (*FSInfo, error) {
+	buf := make([]byte, 120)
+	ok, err := readAt(r, buf, 0, size)
+	if err != nil || !ok {
+		return nil, err
+	}
+	if string(buf[0:4]) != "XFSB" {
+		return nil, nil
+	}
+	return &FSInfo{
+		Type:  "xfs",
+		UUID:  formatByteUUID(buf[32:48]),
+		Label: cString(buf[108:120]),
+	}, nil
+}
+
+func probeBtrfs(r io.ReaderAt, size uint64) (*FSInfo, error) {
+	const superblockOffset = 0x10000
+	buf := make([]byte, 8)
+	ok, err := readAt(r, buf, superblockOffset+0x40, size)
+	if err != nil || !ok {
+		return nil, err
+	}
+	if string(buf) != "_BHRfS_M" {
+		return nil, nil
+	}
+	return &FSInfo{Type: "btrfs"}, nil
+}
+
+func probeSquashfs(r io.ReaderAt, size uint64) (*FSInfo, error) {
+	buf := make([]byte, 4)
+	ok, err := readAt(r, buf, 0, size)
+	if err != nil || !ok {
+		return nil, err
+	}
+	magic := string(buf)
+	if magic != "hsqs" && magic != "sqsh" {
+		return nil, nil
+	}
+	return &FSInfo{Type: "squashfs"}, nil
+}
+
+func probeISO9660(r io.ReaderAt, size uint64) (*FSInfo, error) {
+	const volumeDescriptorOffset = 0x8000
+	buf := make([]byte, 5)
+	ok, err := readAt(r, buf, volumeDescriptorOffset+1, size)
+	if err != nil || !ok {
+		return nil, err
+	}
+	if string(buf) != "CD001" {
+		return nil, nil
+	}
+	label := make([]byte, 32)
+	if ok, err := readAt(r, label, volumeDescriptorOffset+40, size); err != nil {
+		return nil, err
+	} else if !ok {
+		label = nil
+	}
+	return &FSInfo{Type: "iso9660", Label: cString(label)}, nil
+}
+
+func probeLUKS(r io.ReaderAt, size uint64) (*FSInfo, error) {
+	buf := make([]byte, 6)
+	ok, err := readAt(r, buf, 0, size)
+	if err != nil || !ok {
+		return nil, err
+	}
+	if buf[0] != 'L' || buf[1] != 'U' || buf[2] != 'K' || buf[3] != 'S' || buf[4] != 0xBA || buf[5] != 0xBE {
+		return nil, nil
+	}
+	info := &FSInfo{Type: "luks"}
+	uuid := make([]byte, 40)
+	if ok, err := readAt(r, uuid, 168, size); err == nil && ok {
+		info.UUID = cString(uuid)
+	}
+	return info, nil
+}
+
+func probeSwap(r io.ReaderAt, size uint64) (*FSInfo, error) {
+	const pageSize = 4096
+	if size < pageSize {
+		return nil, nil
+	}
+	buf := make([]byte, 10)
+	ok, err := readAt(r, buf, pageSize-10, size)
+	if err != nil || !ok {
+		return nil, err
+	}
+	if string(buf) != "SWAPSPACE2" {
+		return nil, nil
+	}
+	return &FSInfo{Type: "swap"}, nil
+}
+
+// formatByteUUID renders a 16-byte UUID field the conventional
+// 8-4-4-4-12 way
+func formatByteUUID(b []byte) string {
+	if len(b) != 16 {
+		return ""
+	}
+	return hexString(b[0:4]) + "-" + hexString(b[4:6]) + "-" + hexString(b[6:8]) + "-" +
+		hexString(b[8:10]) + "-" + hexString(b[10:16])
+}
+
+func hexString(b []byte) string {
+	const hexDigits = "0123456789abcdef"
+	out := make([]byte, len(b)*2)
+	for i, c := range b {
+		out[i*2] = hexDigits[c>>4]
+		out[i*2+1] = hexDigits[c&0xF]
+	}
+	return string(out)
+}
+
+// formatFATSerial renders the 4-byte FAT volume serial as the
+// conventional XXXX-XXXX string
+func formatFATSerial(b []byte) string {
+	if len(b) != 4 {
+		return ""
+	}
+	serial := binary.LittleEndian.Uint32(b)
+	return hexString([]byte{byte(serial >> 24), byte(serial >> 16)}) + "-" +
+		hexString([]byte{byte(serial >> 8), byte(serial)})
+}
@@ -0,0 +1,312 @@
+package grokdisk
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/tenex/grokdisk/fsprobe"
+	"hash/crc32"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+const (
+	// GPTHeaderSignature is the magic string at the start of a GPT header
+	GPTHeaderSignature = "EFI PART"
+	// GPTHeaderSize is the portion of the GPT header covered by HeaderCRC32
+	GPTHeaderSize = 92
+	// GPTPartitionEntrySize is the size, in bytes, of a single GPT
+	// partition entry in the (overwhelmingly common) case the header
+	// doesn't say otherwise
+	GPTPartitionEntrySize = 128
+)
+
+// candidateSectorSizes are probed, in order, when the GPT header can't be
+// found at the conventional 512-byte LBA1
+var candidateSectorSizes = []uint16{512, 4096}
+
+// GPTHeader is the on-disk GPT header, as found at LBA1 (and mirrored,
+// with Current/Backup swapped, in the backup header at the last LBA)
+type GPTHeader struct {
+	Signature                [8]byte
+	Revision                 uint32
+	HeaderSize               uint32
+	HeaderCRC32              uint32
+	Reserved                 uint32
+	CurrentLBA               uint64
+	BackupLBA                uint64
+	FirstUsableLBA           uint64
+	LastUsableLBA            uint64
+	DiskGUID                 [16]byte
+	PartitionEntryLBA        uint64
+	NumberOfPartitionEntries uint32
+	SizeOfPartitionEntry     uint32
+	PartitionEntryArrayCRC32 uint32
+}
+
+// GPTPartitionMetadata is a single 128-byte GPT partition array entry
+type GPTPartitionMetadata struct {
+	PartitionTypeGUID    [16]byte
+	UniquePartitionGUID  [16]byte
+	StartingLBA          uint64
+	EndingLBA            uint64
+	Attributes           uint64
+	PartitionNameUTF16LE [72]byte
+}
+
+// GPTPartition encapsulates a GPTPartitionMetadata entry with the image
+// it was read from
+type GPTPartition struct {
+	*GPTPartitionMetadata
+	ImageFile *ImageFileMetadata
+	// Filesystem holds the result of fsprobe.Probe, once Probe() or
+	// Open() has been called. It is nil until then.
+	Filesystem *fsprobe.FSInfo
+}
+
+// Start computes the start byte (offset) of the partition
+func (p *GPTPartition) Start() uint64 {
+	return p.StartingLBA * uint64(p.ImageFile.SectorSize)
+}
+
+// Size computes the length of the partition in bytes. GPT's EndingLBA is
+// inclusive, hence the +1.
+func (p *GPTPartition) Size() uint64 {
+	if p.EndingLBA < p.StartingLBA {
+		return 0
+	}
+	return (p.EndingLBA - p.StartingLBA + 1) * uint64(p.ImageFile.SectorSize)
+}
+
+// Type returns the partition type GUID, formatted the conventional way
+func (p *GPTPartition) Type() string {
+	return FormatGUID(p.PartitionTypeGUID)
+}
+
+// Image returns the ImageFileMetadata this partition was read from
+func (p *GPTPartition) Image() *ImageFileMetadata {
+	return p.ImageFile
+}
+
+// Probe identifies the filesystem on this partition and caches the
+// result on Filesystem
+func (p *GPTPartition) Probe() (*fsprobe.FSInfo, error) {
+	info, err := probePartition(p)
+	if err != nil {
+		return nil, err
+	}
+	p.Filesystem = info
+	return info, nil
+}
+
+// Open returns a read-only io/fs.FS for the filesystem on this
+// partition, probing it first if Probe() hasn't already run
+func (p *GPTPartition) Open() (fs.FS, error) {
+	return openPartition(p, &p.Filesystem)
+}
+
+// Name decodes the UTF-16LE partition name, stopping at the first NUL
+func (p *GPTPartition) Name() string {
+	units := make([]uint16, 0, len(p.PartitionNameUTF16LE)/2)
+	for i := 0; i+1 < len(p.PartitionNameUTF16LE); i += 2 {
+		unit := binary.LittleEndian.Uint16(p.PartitionNameUTF16LE[i : i+2])
+		if unit == 0 {
+			break
+		}
+		units = append(units, unit)
+	}
+	return string(utf16.Decode(units))
+}
+
+// UniqueGUID returns the partition's unique GUID, formatted the
+// conventional way
+func (p *GPTPartition) UniqueGUID() string {
+	return FormatGUID(p.UniquePartitionGUID)
+}
+
+// FormatGUID renders a mixed-endian GPT GUID field the way every other
+// tool displays them (first three fields little-endian, last two big-endian)
+func FormatGUID(g [16]byte) string {
+	return fmt.Sprintf("%08X-%04X-%04X-%02X%02X-%02X%02X%02X%02X%02X%02X",
+		binary.LittleEndian.Uint32(g[0:4]),
+		binary.LittleEndian.Uint16(g[4:6]),
+		binary.LittleEndian.Uint16(g[6:8]),
+		g[8], g[9],
+		g[10], g[11], g[12], g[13], g[14], g[15])
+}
+
+// ParseGUID parses the string form produced by FormatGUID back into the
+// mixed-endian on-disk byte layout
+func ParseGUID(s string) ([16]byte, error) {
+	var g [16]byte
+	fields := strings.Split(s, "-")
+	if len(fields) != 5 || len(fields[0]) != 8 || len(fields[1]) != 4 || len(fields[2]) != 4 ||
+		len(fields[3]) != 4 || len(fields[4]) != 12 {
+		return g, errors.Errorf("malformed GUID %q", s)
+	}
+
+	data1, err1 := strconv.ParseUint(fields[0], 16, 32)
+	data2, err2 := strconv.ParseUint(fields[1], 16, 16)
+	data3, err3 := strconv.ParseUint(fields[2], 16, 16)
+	tail, err4 := hex.DecodeString(fields[3] + fields[4])
+	if err1 != nil || err2 != nil || err3 != nil || err4 != nil {
+		return g, errors.Errorf("malformed GUID %q", s)
+	}
+
+	binary.LittleEndian.PutUint32(g[0:4], uint32(data1))
+	binary.LittleEndian.PutUint16(g[4:6], uint16(data2))
+	binary.LittleEndian.PutUint16(g[6:8], uint16(data3))
+	copy(g[8:16], tail)
+	return g, nil
+}
+
+// analyzeGPT parses the GPT header and partition array, preferring the
+// primary copy at LBA1 and falling back to the backup copy at the end of
+// the image if the primary fails CRC32 validation
+func analyzeGPT(imageFile *os.File, imageMetadata *ImageFileMetadata) error {
+	fileInfo, err := imageFile.Stat()
+	if err != nil {
+		return errors.Wrap(err, "could not stat image file")
+	}
+
+	sectorSize, header, err := locatePrimaryGPTHeader(imageFile, fileInfo.Size())
+	if err != nil {
+		return err
+	}
+	imageMetadata.SectorSize = sectorSize
+
+	if err := validateGPTHeader(header); err != nil {
+		backupHeader, backupErr := readGPTHeader(imageFile, lastLBA(fileInfo.Size(), sectorSize), sectorSize)
+		if backupErr != nil || validateGPTHeader(backupHeader) != nil {
+			return errors.Wrap(err, "primary GPT header invalid and backup GPT header unavailable")
+		}
+		header = backupHeader
+	}
+
+	entries, err := readGPTPartitionEntries(imageFile, header, sectorSize, fileInfo.Size())
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		entry := entry
+		// a StartingLBA of 0 marks an unused entry in the array
+		if entry.StartingLBA == 0 {
+			continue
+		}
+		imageMetadata.Partitions = append(imageMetadata.Partitions, &GPTPartition{
+			GPTPartitionMetadata: entry,
+			ImageFile:            imageMetadata,
+		})
+	}
+
+	return nil
+}
+
+// locatePrimaryGPTHeader tries the conventional 512-byte sector size
+// first, then probes a handful of alternatives, confirming each guess by
+// checking the signature and that the header's own LBA bookkeeping is
+// consistent with the file size
+func locatePrimaryGPTHeader(imageFile *os.File, fileSize int64) (uint16, *GPTHeader, error) {
+	var lastErr error
+	for _, sectorSize := range candidateSectorSizes {
+		header, err := readGPTHeader(imageFile, 1, sectorSize)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if string(header.Signature[:]) != GPTHeaderSignature {
+			continue
+		}
+		if header.BackupLBA != 0 && uint64(fileSize) < header.BackupLBA*uint64(sectorSize) {
+			// this sector size puts the backup header past EOF; wrong guess
+			continue
+		}
+		return sectorSize, header, nil
+	}
+	if lastErr == nil {
+		lastErr = errors.New("no GPT header signature found at any candidate sector size")
+	}
+	return 0, nil, lastErr
+}
+
+// readGPTHeader reads and decodes the GPT header at the given LBA
+func readGPTHeader(imageFile *os.File, lba uint64, sectorSize uint16) (*GPTHeader, error) {
+	buf := make([]byte, GPTHeaderSize)
+	if _, err := imageFile.ReadAt(buf, int64(lba)*int64(sectorSize)); err != nil {
+		return nil, errors.Wrap(err, "could not read GPT header")
+	}
+	header := &GPTHeader{}
+	if err := binary.Read(bytes.NewReader(buf), binary.LittleEndian, header); err != nil {
+		return nil, errors.Wrap(err, "could not decode GPT header")
+	}
+	return header, nil
+}
+
+// validateGPTHeader checks the signature and the header's own CRC32
+func validateGPTHeader(header *GPTHeader) error {
+	if string(header.Signature[:]) != GPTHeaderSignature {
+		return errors.New("invalid GPT header signature")
+	}
+
+	buf := &bytes.Buffer{}
+	if err := binary.Write(buf, binary.LittleEndian, header); err != nil {
+		return errors.Wrap(err, "could not re-encode GPT header for CRC32 check")
+	}
+	raw := buf.Bytes()
+	// HeaderCRC32 is computed with the CRC32 field itself zeroed
+	binary.LittleEndian.PutUint32(raw[16:20], 0)
+	if crc32.ChecksumIEEE(raw) != header.HeaderCRC32 {
+		return errors.New("GPT header CRC32 mismatch")
+	}
+	return nil
+}
+
+// readGPTPartitionEntries reads the partition entry array referenced by
+// header and validates it against PartitionEntryArrayCRC32
+func readGPTPartitionEntries(imageFile *os.File, header *GPTHeader, sectorSize uint16, fileSize int64) ([]*GPTPartitionMetadata, error) {
+	entrySize := header.SizeOfPartitionEntry
+	if entrySize == 0 {
+		entrySize = GPTPartitionEntrySize
+	}
+
+	arrayLen := int64(entrySize) * int64(header.NumberOfPartitionEntries)
+	// NumberOfPartitionEntries and SizeOfPartitionEntry come straight off
+	// disk and survive a CRC32 check that's trivial to forge; without
+	// this bound a corrupt or hostile header can claim an array many
+	// times larger than the image file, driving a multi-GB allocation
+	// before ReadAt ever gets a chance to fail on its own.
+	if arrayLen < 0 || arrayLen > fileSize {
+		return nil, errors.Errorf("GPT partition entry array of %d bytes is larger than the image file", arrayLen)
+	}
+	buf := make([]byte, arrayLen)
+	offset := int64(header.PartitionEntryLBA) * int64(sectorSize)
+	if _, err := imageFile.ReadAt(buf, offset); err != nil {
+		return nil, errors.Wrap(err, "could not read GPT partition entry array")
+	}
+
+	if crc32.ChecksumIEEE(buf) != header.PartitionEntryArrayCRC32 {
+		return nil, errors.New("GPT partition entry array CRC32 mismatch")
+	}
+
+	entries := make([]*GPTPartitionMetadata, 0, header.NumberOfPartitionEntries)
+	reader := bytes.NewReader(buf)
+	for i := uint32(0); i < header.NumberOfPartitionEntries; i++ {
+		entry := &GPTPartitionMetadata{}
+		if err := binary.Read(reader, binary.LittleEndian, entry); err != nil {
+			return nil, errors.Wrap(err, "could not decode GPT partition entry")
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// lastLBA returns the final LBA of the image, where the backup GPT header lives
+func lastLBA(fileSize int64, sectorSize uint16) uint64 {
+	return uint64(fileSize)/uint64(sectorSize) - 1
+}
@@ -4,6 +4,8 @@ import (
 	"encoding/binary"
 	"fmt"
 	"github.com/pkg/errors"
+	"github.com/tenex/grokdisk/fsprobe"
+	"io/fs"
 	"os"
 )
 
@@ -12,8 +14,57 @@ const (
 	MBRPartitionTableOffset = 0x1BE
 	// MBRPartitionTableSize is the length of the partition table in bytes
 	MBRPartitionTableSize = 0x10
+	// MBRProtectiveType is the PartitionType byte used by a protective MBR
+	// to mark the disk as GPT-formatted
+	MBRProtectiveType = 0xEE
 )
 
+// TableType identifies which partition table scheme an image uses
+type TableType int
+
+const (
+	// TableTypeMBR is a classic DOS/MBR partition table
+	TableTypeMBR TableType = iota
+	// TableTypeGPT is a GUID Partition Table behind a protective MBR
+	TableTypeGPT
+	// TableTypeHybridGPT is a GPT disk whose protective MBR also carries
+	// real (non-0xEE) entries for legacy BIOS compatibility
+	TableTypeHybridGPT
+)
+
+func (t TableType) String() string {
+	switch t {
+	case TableTypeMBR:
+		return "MBR"
+	case TableTypeGPT:
+		return "GPT"
+	case TableTypeHybridGPT:
+		return "HybridGPT"
+	default:
+		return "unknown"
+	}
+}
+
+// Partition is the common surface exposed by both MBR and GPT partition
+// entries so callers don't need to branch on TableType to read basic
+// layout information
+type Partition interface {
+	// Start returns the byte offset of the start of the partition
+	Start() uint64
+	// Size returns the length of the partition in bytes
+	Size() uint64
+	// Type returns a human readable description of the partition type
+	Type() string
+	// Name returns the partition's name, or "" if the table format
+	// doesn't carry one (MBR)
+	Name() string
+	// Image returns the ImageFileMetadata this partition was read from
+	Image() *ImageFileMetadata
+	// Open returns a read-only io/fs.FS for the filesystem on this
+	// partition, probing it first if Probe() hasn't already run
+	Open() (fs.FS, error)
+}
+
 // AnalyzeImageFile enumerates partitions, determining information necessary
 // to mount the image locally. This includes:
 // * Type of partition table (GUID vs. MBR)
@@ -27,49 +78,141 @@ func AnalyzeImageFile(path string) (*ImageFileMetadata, error) {
 	}
 	defer imageFile.Close()
 
-	if _, err := imageFile.Seek(MBRPartitionTableOffset, os.SEEK_SET); err != nil {
-		return nil, errors.Wrap(err, "could not seek partition table")
-	}
-
 	imageMetadata := &ImageFileMetadata{
 		SectorSize: 512,
 		Filepath:   path,
-		Partitions: make([]*Partition, 0, 4),
+		Partitions: make([]Partition, 0, 4),
 	}
 
-	// TODO: Expand for "extended partitions"
-	// TODO: Account for GPT
-	// Iterate over the four possible logical partitions in MBR
-	for partitionIndex := 0; partitionIndex < 4; partitionIndex++ {
-		metadata := &PartitionMetadata{}
-		err = binary.Read(imageFile, binary.LittleEndian, metadata)
-		if err != nil {
-			return nil, errors.Wrap(err, "could not read partition entry")
+	mbrEntries, err := readMBRTable(imageFile)
+	if err != nil {
+		return nil, err
+	}
+
+	if protectiveMBREntry(mbrEntries) != nil {
+		imageMetadata.TableType = TableTypeGPT
+		if err := analyzeGPT(imageFile, imageMetadata); err != nil {
+			return nil, err
 		}
-		partition := &Partition{
+
+		// A hybrid GPT disk keeps real (non-0xEE, non-empty) entries in
+		// the protective MBR alongside the 0xEE marker, for legacy BIOS
+		// bootloaders that only understand MBR. Surface those as
+		// ordinary MBR partitions too, rather than discarding them.
+		for _, metadata := range mbrEntries {
+			metadata := metadata
+			if metadata.PartitionType == 0 || metadata.PartitionType == MBRProtectiveType {
+				continue
+			}
+			imageMetadata.TableType = TableTypeHybridGPT
+			imageMetadata.Partitions = append(imageMetadata.Partitions, &MBRPartition{
+				PartitionMetadata: metadata,
+				ImageFile:         imageMetadata,
+			})
+		}
+
+		return imageMetadata, nil
+	}
+
+	imageMetadata.TableType = TableTypeMBR
+
+	for _, metadata := range mbrEntries {
+		metadata := metadata
+		partition := &MBRPartition{
 			PartitionMetadata: metadata,
 			ImageFile:         imageMetadata,
 		}
 		imageMetadata.Partitions = append(imageMetadata.Partitions, partition)
+
+		if !isExtendedPartitionType(metadata.PartitionType) {
+			continue
+		}
+		logicalPartitions, err := readExtendedChain(imageFile, imageMetadata, partition)
+		if err != nil {
+			return nil, err
+		}
+		for _, logical := range logicalPartitions {
+			imageMetadata.Partitions = append(imageMetadata.Partitions, logical)
+		}
 	}
 
 	return imageMetadata, nil
 }
 
-// ImageFileMetadata contains the list of 16-byte partition table entries
+// readMBRTable reads the four primary partition entries at
+// MBRPartitionTableOffset, whether or not they turn out to be a
+// protective MBR for a GPT disk
+func readMBRTable(imageFile *os.File) ([]*PartitionMetadata, error) {
+	if _, err := imageFile.Seek(MBRPartitionTableOffset, os.SEEK_SET); err != nil {
+		return nil, errors.Wrap(err, "could not seek partition table")
+	}
+
+	entries := make([]*PartitionMetadata, 0, 4)
+	for partitionIndex := 0; partitionIndex < 4; partitionIndex++ {
+		metadata := &PartitionMetadata{}
+		if err := binary.Read(imageFile, binary.LittleEndian, metadata); err != nil {
+			return nil, errors.Wrap(err, "could not read partition entry")
+		}
+		entries = append(entries, metadata)
+	}
+	return entries, nil
+}
+
+// protectiveMBREntry returns the single 0xEE entry that marks a protective
+// MBR, or nil if entries describes an ordinary MBR table
+func protectiveMBREntry(entries []*PartitionMetadata) *PartitionMetadata {
+	var protective *PartitionMetadata
+	for _, entry := range entries {
+		if entry.PartitionType != MBRProtectiveType {
+			continue
+		}
+		if protective != nil {
+			// more than one 0xEE entry isn't a protective MBR
+			return nil
+		}
+		protective = entry
+	}
+	return protective
+}
+
+// ImageFileMetadata contains the list of partition table entries
 // and metadata associated with their use
 type ImageFileMetadata struct {
 	SectorSize uint16
 	Filepath   string
-	Partitions []*Partition
+	TableType  TableType
+	Partitions []Partition
+
+	// file is the lazily opened, shared handle SectionReader reads
+	// through; it's opened once and reused rather than reopened on
+	// every call. Close it when done with the image.
+	file *os.File
 }
 
-// Partition encapsulates the low level data from
+// Close releases the image file handle opened lazily by SectionReader.
+// It is a no-op if SectionReader was never called.
+func (m *ImageFileMetadata) Close() error {
+	if m.file == nil {
+		return nil
+	}
+	err := m.file.Close()
+	m.file = nil
+	return err
+}
+
+// MBRPartition encapsulates the low level data from
 // PartitionMetadata and provides additional computed data
-type Partition struct {
+type MBRPartition struct {
 	*PartitionMetadata
 	// Pointer to image file in which this partition was found
 	ImageFile *ImageFileMetadata
+	// ParentExtended is set for logical partitions discovered inside an
+	// EBR chain, pointing back at the primary extended partition that
+	// contains them. It is nil for primary partitions.
+	ParentExtended *MBRPartition
+	// Filesystem holds the result of fsprobe.Probe, once Probe() or
+	// Open() has been called. It is nil until then.
+	Filesystem *fsprobe.FSInfo
 }
 
 // PartitionMetadata represents the 16-byte partition table entry from
@@ -88,19 +231,52 @@ type PartitionMetadata struct {
 }
 
 // Start computes start byte (offset) of partition
-func (p *Partition) Start() uint64 {
-	return (uint64(p.FirstSectorLBA) * uint64(p.ImageFile.SectorSize))
+func (p *MBRPartition) Start() uint64 {
+	return uint64(p.FirstSectorLBA) * uint64(p.ImageFile.SectorSize)
 }
 
 // Size computes the length of the partition
-func (p *Partition) Size() uint64 {
-	return (uint64(p.SectorCount) * uint64(p.ImageFile.SectorSize))
+func (p *MBRPartition) Size() uint64 {
+	return uint64(p.SectorCount) * uint64(p.ImageFile.SectorSize)
+}
+
+// Type returns the partition type byte and, where recognized, its
+// well-known name, e.g. "0x83 Linux"
+func (p *MBRPartition) Type() string {
+	return partitionTypeString(p.PartitionType)
+}
+
+// Name returns "" since MBR partition entries don't carry a name
+func (p *MBRPartition) Name() string {
+	return ""
+}
+
+// Image returns the ImageFileMetadata this partition was read from
+func (p *MBRPartition) Image() *ImageFileMetadata {
+	return p.ImageFile
+}
+
+// Probe identifies the filesystem on this partition and caches the
+// result on Filesystem
+func (p *MBRPartition) Probe() (*fsprobe.FSInfo, error) {
+	info, err := probePartition(p)
+	if err != nil {
+		return nil, err
+	}
+	p.Filesystem = info
+	return info, nil
+}
+
+// Open returns a read-only io/fs.FS for the filesystem on this
+// partition, probing it first if Probe() hasn't already run
+func (p *MBRPartition) Open() (fs.FS, error) {
+	return openPartition(p, &p.Filesystem)
 }
 
-func (p *Partition) String() string {
+func (p *MBRPartition) String() string {
 	return fmt.Sprintf(
 		"status: %v type: %v, start: %v sectors (%v B), length: %v sectors (%v B)",
-		p.Status, p.PartitionType,
+		p.Status, p.Type(),
 		p.FirstSectorLBA, p.Start(),
 		p.SectorCount, p.Size())
 }
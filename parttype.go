@@ -0,0 +1,113 @@
+package grokdisk
+
+import "fmt"
+
+// partitionTypeInfo names and describes a well-known MBR PartitionType byte
+type partitionTypeInfo struct {
+	Name        string
+	Description string
+}
+
+// partitionTypes maps MBR PartitionType bytes to their well-known name
+// and a short description, covering the type bytes actually seen in
+// the wild. Source: the partition type list maintained across fdisk,
+// parted, and the Linux kernel's partition-type table.
+var partitionTypes = map[byte]partitionTypeInfo{
+	0x00: {"Empty", "Unused partition table entry"},
+	0x01: {"FAT12", "DOS 12-bit FAT"},
+	0x04: {"FAT16 <32M", "DOS 16-bit FAT, partition <32MB"},
+	0x05: {"Extended", "CHS-addressed extended partition container"},
+	0x06: {"FAT16", "DOS 16-bit FAT, partition >=32MB"},
+	0x07: {"HPFS/NTFS/exFAT", "IFS: OS/2 HPFS, Windows NTFS, or exFAT"},
+	0x0B: {"W95 FAT32", "Windows 95 32-bit FAT"},
+	0x0C: {"W95 FAT32 (LBA)", "Windows 95 32-bit FAT, LBA-addressed"},
+	0x0E: {"W95 FAT16 (LBA)", "Windows 95 16-bit FAT, LBA-addressed"},
+	0x0F: {"W95 Extended (LBA)", "LBA-addressed extended partition container"},
+	0x11: {"Hidden FAT12", "Hidden DOS 12-bit FAT"},
+	0x12: {"Compaq diagnostics", "Compaq diagnostic partition"},
+	0x14: {"Hidden FAT16 <32M", "Hidden DOS 16-bit FAT, partition <32MB"},
+	0x16: {"Hidden FAT16", "Hidden DOS 16-bit FAT, partition >=32MB"},
+	0x17: {"Hidden HPFS/NTFS", "Hidden OS/2 HPFS or Windows NTFS"},
+	0x1B: {"Hidden W95 FAT32", "Hidden Windows 95 32-bit FAT"},
+	0x1C: {"Hidden W95 FAT32 (LBA)", "Hidden Windows 95 32-bit FAT, LBA-addressed"},
+	0x1E: {"Hidden W95 FAT16 (LBA)", "Hidden Windows 95 16-bit FAT, LBA-addressed"},
+	0x27: {"Windows RE", "Windows Recovery Environment"},
+	0x39: {"Plan 9", "Plan 9 partition"},
+	0x3C: {"PartitionMagic", "PowerQuest PartitionMagic recovery partition"},
+	0x42: {"SFS/Win LDM", "Windows dynamic disk (LDM) or SFS"},
+	0x44: {"GoBack", "Norton GoBack"},
+	0x51: {"Novell", "Novell partition"},
+	0x52: {"CP/M", "CP/M or Microport SysV/AT"},
+	0x63: {"GNU HURD/SysV", "GNU HURD or System V (Unixware, Xenix)"},
+	0x64: {"Netware 286", "Novell Netware 286"},
+	0x65: {"Netware 386", "Novell Netware 386"},
+	0x70: {"DiskSecure", "DiskSecure multi-boot"},
+	0x75: {"PC/IX", "PC/IX"},
+	0x80: {"Old Minix", "Minix <=1.4a"},
+	0x81: {"Minix / old Linux", "Minix >=1.4b or old Linux"},
+	0x82: {"Linux swap / Solaris", "Linux swap, or Solaris x86"},
+	0x83: {"Linux", "Linux native filesystem"},
+	0x84: {"OS/2 hidden C: drive", "Hibernation partition"},
+	0x85: {"Linux extended", "Extended partition container, Linux convention"},
+	0x86: {"NTFS volume set", "Legacy Windows NT FTDISK volume set"},
+	0x87: {"NTFS volume set", "Legacy Windows NT FTDISK volume set"},
+	0x88: {"Linux plaintext", "Linux plaintext partition table"},
+	0x8E: {"Linux LVM", "Linux Logical Volume Manager"},
+	0x93: {"Amoeba", "Amoeba native filesystem"},
+	0x94: {"Amoeba BBT", "Amoeba bad block table"},
+	0x9F: {"BSD/OS", "BSDI BSD/OS"},
+	0xA0: {"IBM Thinkpad hibernation", "Laptop hibernation partition"},
+	0xA5: {"FreeBSD", "FreeBSD/386 slice"},
+	0xA6: {"OpenBSD", "OpenBSD slice"},
+	0xA7: {"NeXTSTEP", "NeXTSTEP partition"},
+	0xA8: {"Darwin UFS", "Mac OS X UFS"},
+	0xA9: {"NetBSD", "NetBSD slice"},
+	0xAB: {"Darwin boot", "Mac OS X boot partition"},
+	0xAF: {"HFS / HFS+", "Mac OS X HFS or HFS+"},
+	0xB7: {"BSDI fs", "BSDI native filesystem"},
+	0xB8: {"BSDI swap", "BSDI swap"},
+	0xBB: {"Boot Wizard hidden", "PQservice hidden partition"},
+	0xBC: {"Acronis FAT32 (LBA)", "Acronis Secure Zone"},
+	0xBE: {"Solaris boot", "Solaris x86 boot partition"},
+	0xBF: {"Solaris", "Solaris x86 partition"},
+	0xC1: {"DRDOS/sec FAT12", "Hidden/secured DR-DOS 12-bit FAT"},
+	0xC4: {"DRDOS/sec FAT16 <32M", "Hidden/secured DR-DOS 16-bit FAT, <32MB"},
+	0xC6: {"DRDOS/sec FAT16", "Hidden/secured DR-DOS 16-bit FAT, >=32MB"},
+	0xC7: {"Syrinx", "Syrinx boot partition"},
+	0xDA: {"Non-FS data", "Raw data partition"},
+	0xDB: {"CP/M / CTOS", "CP/M-86 or Concurrent CP/M / CTOS"},
+	0xDE: {"Dell Utility", "Dell PowerEdge utility partition"},
+	0xDF: {"BootIt", "BootIt EMBRM"},
+	0xE1: {"DOS access", "DOS access or SpeedStor 12-bit FAT extended"},
+	0xE3: {"DOS R/O", "DOS R/O or SpeedStor"},
+	0xE4: {"SpeedStor", "SpeedStor 16-bit FAT extended, <1024 cyl"},
+	0xEA: {"Linux extended boot", "Linux extended boot (XBOOTLDR)"},
+	0xEB: {"BeOS", "BeOS filesystem"},
+	0xEE: {"GPT protective", "GPT protective MBR marker"},
+	0xEF: {"EFI System", "EFI System Partition (ESP) on an MBR disk"},
+	0xF0: {"Linux/PA-RISC boot", "Linux/PA-RISC boot loader"},
+	0xF1: {"SpeedStor", "SpeedStor"},
+	0xF2: {"DOS secondary", "DOS 3.3+ secondary partition"},
+	0xF4: {"SpeedStor", "SpeedStor large partition"},
+	0xFB: {"VMware VMFS", "VMware VMFS filesystem"},
+	0xFC: {"VMware VMKCORE", "VMware kernel dump partition"},
+	0xFD: {"Linux raid autodetect", "Linux RAID superblock autodetect"},
+	0xFE: {"LANstep", "SpeedStor or old Windows NT hidden"},
+	0xFF: {"BBT", "Xenix bad block table"},
+}
+
+// LookupPartitionType returns the name and description for a well-known
+// MBR partition type byte. ok is false for unrecognized bytes.
+func LookupPartitionType(partitionType byte) (info partitionTypeInfo, ok bool) {
+	info, ok = partitionTypes[partitionType]
+	return info, ok
+}
+
+// partitionTypeString renders a PartitionType byte the way fdisk does:
+// "0x83 Linux", falling back to just the hex byte for unrecognized types
+func partitionTypeString(partitionType byte) string {
+	if info, ok := partitionTypes[partitionType]; ok {
+		return fmt.Sprintf("0x%02X %s", partitionType, info.Name)
+	}
+	return fmt.Sprintf("0x%02X", partitionType)
+}
@@ -0,0 +1,68 @@
+package grokdisk
+
+import (
+	"github.com/pkg/errors"
+	"github.com/tenex/grokdisk/fs/ext"
+	"github.com/tenex/grokdisk/fs/fat"
+	"github.com/tenex/grokdisk/fsprobe"
+	"io"
+	iofs "io/fs"
+	"os"
+	"strings"
+)
+
+// SectionReader returns a reader bounded to exactly the byte range
+// occupied by p, suitable for handing to fsprobe or a filesystem driver
+// without giving either access to the rest of the image. The underlying
+// image file is opened once per ImageFileMetadata and reused across
+// calls; call m.Close() when done with the image.
+func (m *ImageFileMetadata) SectionReader(p Partition) (*io.SectionReader, error) {
+	if m.file == nil {
+		imageFile, err := os.Open(m.Filepath)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not open image file")
+		}
+		m.file = imageFile
+	}
+	return io.NewSectionReader(m.file, int64(p.Start()), int64(p.Size())), nil
+}
+
+// probePartition opens p and runs fsprobe.Probe against it
+func probePartition(p Partition) (*fsprobe.FSInfo, error) {
+	section, err := p.Image().SectionReader(p)
+	if err != nil {
+		return nil, err
+	}
+	return fsprobe.Probe(section, p.Size())
+}
+
+// openPartition probes p (if cache is nil) and constructs the matching
+// read-only driver from grokdisk/fs
+func openPartition(p Partition, cache **fsprobe.FSInfo) (iofs.FS, error) {
+	info := *cache
+	if info == nil {
+		var err error
+		info, err = probePartition(p)
+		if err != nil {
+			return nil, err
+		}
+		if info == nil {
+			return nil, errors.New("could not identify filesystem")
+		}
+		*cache = info
+	}
+
+	section, err := p.Image().SectionReader(p)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case strings.HasPrefix(info.Type, "fat"):
+		return fat.New(section, p.Size())
+	case info.Type == "ext2/3/4":
+		return ext.New(section, p.Size())
+	default:
+		return nil, errors.Errorf("no filesystem driver for %q", info.Type)
+	}
+}